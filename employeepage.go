@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"gopkg.in/telebot.v3"
+)
+
+// employeesPerPage is how many employees are shown per inline-keyboard page
+// in /list, /remove and /edit.
+const employeesPerPage = 10
+
+// employeePageMode selects which per-row action (and title) renderEmployeePage
+// builds its keyboard for; it is carried through the callback data so
+// pagination and the per-row action both return to the right flow.
+type employeePageMode string
+
+const (
+	employeePageModeList   employeePageMode = "list"
+	employeePageModeRemove employeePageMode = "remove"
+	employeePageModeEdit   employeePageMode = "edit"
+)
+
+var btnEmployeePage = telebot.InlineButton{Unique: "employee_page"}
+
+// renderEmployeePage builds the text and inline keyboard for one page of an
+// employee list, shared by /list, /remove and /edit. Each row button carries
+// "<mode>:<action>:<employeeID>:<page>" as its callback data so a single
+// handler (employeePageCallback) can dispatch view/delete/edit-pick and
+// pagination for all three commands.
+func renderEmployeePage(employees []Employee, page int, mode employeePageMode) (string, *telebot.ReplyMarkup) {
+	markup := &telebot.ReplyMarkup{}
+
+	if len(employees) == 0 {
+		return "ℹ️ Список сотрудников пуст", markup
+	}
+
+	totalPages := (len(employees) + employeesPerPage - 1) / employeesPerPage
+	if page < 0 {
+		page = 0
+	}
+	if page >= totalPages {
+		page = totalPages - 1
+	}
+
+	start := page * employeesPerPage
+	end := start + employeesPerPage
+	if end > len(employees) {
+		end = len(employees)
+	}
+
+	var title, action string
+	switch mode {
+	case employeePageModeRemove:
+		title = "🗑 Выберите сотрудника для удаления:"
+		action = "del"
+	case employeePageModeEdit:
+		title = "✏️ Выберите сотрудника для редактирования:"
+		action = "editpick"
+	default:
+		title = "📋 Список сотрудников:"
+		action = "view"
+	}
+
+	var b strings.Builder
+	b.WriteString(title)
+	b.WriteString(fmt.Sprintf("\nСтраница %d из %d", page+1, totalPages))
+
+	var rows []telebot.Row
+	for _, emp := range employees[start:end] {
+		label := fmt.Sprintf("%s (%s)", emp.Name, emp.Birthday.Format("02.01.2006"))
+		data := fmt.Sprintf("%s:%s:%d:%d", mode, action, emp.ID, page)
+		rows = append(rows, markup.Row(markup.Data(label, btnEmployeePage.Unique, data)))
+	}
+
+	var nav []telebot.Btn
+	if page > 0 {
+		nav = append(nav, markup.Data("◀", btnEmployeePage.Unique, fmt.Sprintf("%s:page:0:%d", mode, page-1)))
+	}
+	nav = append(nav, markup.Data(fmt.Sprintf("%d/%d", page+1, totalPages), btnEmployeePage.Unique, fmt.Sprintf("%s:noop:0:%d", mode, page)))
+	if page < totalPages-1 {
+		nav = append(nav, markup.Data("▶", btnEmployeePage.Unique, fmt.Sprintf("%s:page:0:%d", mode, page+1)))
+	}
+	rows = append(rows, markup.Row(nav...))
+
+	markup.Inline(rows...)
+	return b.String(), markup
+}
+
+func employeePageCallback(c telebot.Context) error {
+	parts := strings.SplitN(c.Callback().Data, ":", 4)
+	if len(parts) != 4 {
+		return c.Respond(&telebot.CallbackResponse{Text: "❌ Некорректные данные"})
+	}
+	mode := employeePageMode(parts[0])
+	action := parts[1]
+
+	employeeID, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return c.Respond(&telebot.CallbackResponse{Text: "❌ Некорректные данные"})
+	}
+	page, err := strconv.Atoi(parts[3])
+	if err != nil {
+		return c.Respond(&telebot.CallbackResponse{Text: "❌ Некорректные данные"})
+	}
+
+	switch action {
+	case "noop":
+		return c.Respond()
+	case "page":
+		return editEmployeePage(c, mode, page)
+	case "view":
+		return viewEmployeeCallback(c, employeeID)
+	case "del":
+		return deleteEmployeeCallback(c, mode, employeeID, page)
+	case "editpick":
+		return editPickCallback(c, employeeID)
+	default:
+		return c.Respond(&telebot.CallbackResponse{Text: "❌ Неизвестное действие"})
+	}
+}
+
+// employeeOrRespond looks up employeeID scoped to the current chat and, if
+// it isn't found there, sends the "not found" callback response itself so
+// callers can just check for a nil employee.
+func employeeOrRespond(c telebot.Context, employeeID int) (*Employee, error) {
+	employee, err := getChatEmployee(c.Chat().ID, employeeID)
+	if err != nil {
+		log.Println("Ошибка получения сотрудника:", err)
+		return nil, c.Respond(&telebot.CallbackResponse{Text: "❌ Ошибка"})
+	}
+	if employee == nil {
+		return nil, c.Respond(&telebot.CallbackResponse{Text: "❌ Сотрудник не найден"})
+	}
+	return employee, nil
+}
+
+// editEmployeePage re-renders the message in place for a pagination tap.
+func editEmployeePage(c telebot.Context, mode employeePageMode, page int) error {
+	chatID := c.Chat().ID
+
+	employees, err := getEmployeesByChat(chatID)
+	if err != nil {
+		log.Println("Ошибка получения списка:", err)
+		return c.Respond(&telebot.CallbackResponse{Text: "❌ Ошибка"})
+	}
+
+	text, markup := renderEmployeePage(employees, page, mode)
+	if err := c.Edit(text, markup); err != nil {
+		log.Println("Ошибка обновления списка сотрудников:", err)
+	}
+	return c.Respond()
+}
+
+func viewEmployeeCallback(c telebot.Context, employeeID int) error {
+	employee, err := employeeOrRespond(c, employeeID)
+	if employee == nil {
+		return err
+	}
+
+	return c.Respond(&telebot.CallbackResponse{
+		Text:      fmt.Sprintf("%s — %s", employee.Name, employee.Birthday.Format("02.01.2006")),
+		ShowAlert: true,
+	})
+}
+
+func deleteEmployeeCallback(c telebot.Context, mode employeePageMode, employeeID int, page int) error {
+	chatID := c.Chat().ID
+	if !hasRole(c, RoleEditor) {
+		return c.Respond(&telebot.CallbackResponse{Text: "⛔ Недостаточно прав"})
+	}
+
+	employee, err := employeeOrRespond(c, employeeID)
+	if employee == nil {
+		return err
+	}
+
+	if err := deleteEmployee(employeeID); err != nil {
+		log.Println("Ошибка удаления:", err)
+		return c.Respond(&telebot.CallbackResponse{Text: "❌ Ошибка при удалении"})
+	}
+
+	employees, err := getEmployeesByChat(chatID)
+	if err != nil {
+		log.Println("Ошибка получения списка:", err)
+	}
+
+	text, markup := renderEmployeePage(employees, page, mode)
+	if err := c.Edit(text, markup); err != nil {
+		log.Println("Ошибка обновления списка сотрудников:", err)
+	}
+
+	return c.Respond(&telebot.CallbackResponse{Text: fmt.Sprintf("✅ %s удалён", employee.Name)})
+}
+
+func editPickCallback(c telebot.Context, employeeID int) error {
+	chatID := c.Chat().ID
+	if !hasRole(c, RoleEditor) {
+		return c.Respond(&telebot.CallbackResponse{Text: "⛔ Недостаточно прав"})
+	}
+
+	employee, err := employeeOrRespond(c, employeeID)
+	if employee == nil {
+		return err
+	}
+
+	if err := setChatState(chatID, StateAwaitingEditField, editFieldPayload{EmployeeID: employeeID}); err != nil {
+		log.Println("Ошибка сохранения состояния:", err)
+		return c.Respond(&telebot.CallbackResponse{Text: "❌ Ошибка"})
+	}
+
+	if err := c.Send(fmt.Sprintf("Что изменить у %s?\n1. Имя\n2. Дата рождения", employee.Name)); err != nil {
+		log.Println("Ошибка отправки сообщения:", err)
+	}
+	return c.Respond()
+}
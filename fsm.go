@@ -0,0 +1,293 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/telebot.v3"
+)
+
+// ChatState is the name of a node in the per-chat conversation FSM.
+type ChatState string
+
+const (
+	StateIdle                ChatState = "idle"
+	StateAwaitingAdd         ChatState = "awaiting_add"
+	StateAwaitingEditField   ChatState = "awaiting_edit_field"
+	StateAwaitingEditValue   ChatState = "awaiting_edit_value"
+	StateAwaitingSettingsVal ChatState = "awaiting_settings_value"
+)
+
+// chatStateTTL is how long a chat may sit in a non-idle state before the
+// cleanup goroutine resets it, so an abandoned /add or /edit flow
+// doesn't make the next unrelated message misinterpreted forever.
+const chatStateTTL = 10 * time.Minute
+
+// StateRecord is a chat's current FSM node plus whatever data that node
+// needs to act on the next message (which employee, which field, ...).
+type StateRecord struct {
+	ChatID  int64
+	State   ChatState
+	Payload json.RawMessage
+}
+
+type editFieldPayload struct {
+	EmployeeID int `json:"employee_id"`
+}
+
+type editValuePayload struct {
+	EmployeeID int    `json:"employee_id"`
+	Field      string `json:"field"`
+}
+
+func getChatState(chatID int64) (StateRecord, error) {
+	var stateStr, payloadStr string
+	err := db.QueryRow("SELECT state, payload_json FROM chat_state WHERE chat_id = ?", chatID).Scan(&stateStr, &payloadStr)
+	switch err {
+	case nil:
+		return StateRecord{ChatID: chatID, State: ChatState(stateStr), Payload: json.RawMessage(payloadStr)}, nil
+	case sql.ErrNoRows:
+		return StateRecord{ChatID: chatID, State: StateIdle, Payload: json.RawMessage("{}")}, nil
+	default:
+		return StateRecord{}, err
+	}
+}
+
+func setChatState(chatID int64, state ChatState, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		`INSERT INTO chat_state (chat_id, state, payload_json, updated_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(chat_id) DO UPDATE SET
+			state = excluded.state,
+			payload_json = excluded.payload_json,
+			updated_at = excluded.updated_at`,
+		chatID, string(state), string(data),
+	)
+	return err
+}
+
+func clearChatState(chatID int64) error {
+	_, err := db.Exec("DELETE FROM chat_state WHERE chat_id = ?", chatID)
+	return err
+}
+
+// expireStaleChatStates resets any chat that has been sitting in a
+// non-idle state for longer than chatStateTTL.
+func expireStaleChatStates() error {
+	_, err := db.Exec(
+		"DELETE FROM chat_state WHERE updated_at < datetime('now', ?)",
+		fmt.Sprintf("-%d seconds", int(chatStateTTL.Seconds())),
+	)
+	return err
+}
+
+func startStateCleanup() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := expireStaleChatStates(); err != nil {
+			log.Println("Ошибка очистки состояний чатов:", err)
+		}
+	}
+}
+
+func cancelHandler(c telebot.Context) error {
+	chatID := c.Chat().ID
+	updateActiveChat(chatID)
+
+	if err := clearChatState(chatID); err != nil {
+		log.Println("Ошибка сброса состояния:", err)
+		return c.Send("❌ Ошибка при сбросе состояния")
+	}
+
+	return c.Send("Отменено")
+}
+
+func editHandler(c telebot.Context) error {
+	chatID := c.Chat().ID
+	updateActiveChat(chatID)
+
+	employees, err := getEmployeesByChat(chatID)
+	if err != nil {
+		log.Println("Ошибка получения списка:", err)
+		return c.Send("❌ Ошибка при получении списка сотрудников")
+	}
+
+	text, markup := renderEmployeePage(employees, 0, employeePageModeEdit)
+	return c.Send(text, markup)
+}
+
+func handleAddText(c telebot.Context, text string) error {
+	chatID := c.Chat().ID
+
+	if !hasRole(c, RoleEditor) {
+		clearChatState(chatID)
+		return c.Send("⛔ Недостаточно прав для добавления сотрудников")
+	}
+
+	parts := strings.Split(text, " ")
+	if len(parts) < 3 {
+		return c.Send("❌ Неверный формат. Используйте: Имя Фамилия ДД.ММ.ГГГГ")
+	}
+
+	name := strings.Join(parts[:len(parts)-1], " ")
+	dateStr := parts[len(parts)-1]
+
+	birthday, err := time.Parse("02.01.2006", dateStr)
+	if err != nil {
+		return c.Send("❌ Неверный формат даты. Используйте ДД.ММ.ГГГГ")
+	}
+
+	if err := addEmployeeTx(name, birthday, chatID); err != nil {
+		log.Println("Ошибка добавления:", err)
+		return c.Send("❌ Ошибка при добавлении сотрудника")
+	}
+
+	clearChatState(chatID)
+	return c.Send(fmt.Sprintf("✅ Сотрудник %s добавлен (день рождения: %s)",
+		name, birthday.Format("02.01.2006")))
+}
+
+func handleEditField(c telebot.Context, state StateRecord, text string) error {
+	chatID := c.Chat().ID
+
+	if !hasRole(c, RoleEditor) {
+		clearChatState(chatID)
+		return c.Send("⛔ Недостаточно прав для редактирования сотрудников")
+	}
+
+	var payload editFieldPayload
+	if err := json.Unmarshal(state.Payload, &payload); err != nil {
+		clearChatState(chatID)
+		return c.Send("❌ Состояние повреждено, начните заново с /edit")
+	}
+
+	employee, err := getChatEmployee(chatID, payload.EmployeeID)
+	if err != nil {
+		log.Println("Ошибка получения сотрудника:", err)
+		clearChatState(chatID)
+		return c.Send("❌ Ошибка")
+	}
+	if employee == nil {
+		clearChatState(chatID)
+		return c.Send("❌ Сотрудник не найден, начните заново с /edit")
+	}
+
+	var field string
+	switch strings.TrimSpace(text) {
+	case "1":
+		field = "name"
+	case "2":
+		field = "birthday"
+	default:
+		return c.Send("❌ Отправьте 1 (имя) или 2 (дата рождения)")
+	}
+
+	if err := setChatState(chatID, StateAwaitingEditValue, editValuePayload{EmployeeID: payload.EmployeeID, Field: field}); err != nil {
+		log.Println("Ошибка сохранения состояния:", err)
+		return c.Send("❌ Ошибка")
+	}
+
+	if field == "name" {
+		return c.Send("Введите новое имя")
+	}
+	return c.Send("Введите новую дату рождения в формате ДД.ММ.ГГГГ")
+}
+
+func handleEditValue(c telebot.Context, state StateRecord, text string) error {
+	chatID := c.Chat().ID
+
+	if !hasRole(c, RoleEditor) {
+		clearChatState(chatID)
+		return c.Send("⛔ Недостаточно прав для редактирования сотрудников")
+	}
+
+	var payload editValuePayload
+	if err := json.Unmarshal(state.Payload, &payload); err != nil {
+		clearChatState(chatID)
+		return c.Send("❌ Состояние повреждено, начните заново с /edit")
+	}
+
+	employee, err := getChatEmployee(chatID, payload.EmployeeID)
+	if err != nil {
+		log.Println("Ошибка получения сотрудника:", err)
+		clearChatState(chatID)
+		return c.Send("❌ Ошибка")
+	}
+	if employee == nil {
+		clearChatState(chatID)
+		return c.Send("❌ Сотрудник не найден, начните заново с /edit")
+	}
+
+	switch payload.Field {
+	case "name":
+		name := strings.TrimSpace(text)
+		if name == "" {
+			return c.Send("❌ Имя не может быть пустым")
+		}
+		if err := updateEmployeeName(payload.EmployeeID, name); err != nil {
+			log.Println("Ошибка обновления имени:", err)
+			clearChatState(chatID)
+			return c.Send("❌ Ошибка при сохранении")
+		}
+	case "birthday":
+		birthday, err := time.Parse("02.01.2006", strings.TrimSpace(text))
+		if err != nil {
+			return c.Send("❌ Неверный формат даты. Используйте ДД.ММ.ГГГГ")
+		}
+		if err := updateEmployeeBirthday(payload.EmployeeID, birthday); err != nil {
+			log.Println("Ошибка обновления даты рождения:", err)
+			clearChatState(chatID)
+			return c.Send("❌ Ошибка при сохранении")
+		}
+		notifyBirthdayChanged(c, employee.Name, chatID, payload.EmployeeID, birthday)
+	default:
+		clearChatState(chatID)
+		return c.Send("❌ Состояние повреждено, начните заново с /edit")
+	}
+
+	clearChatState(chatID)
+	return c.Send("✅ Сотрудник обновлён")
+}
+
+func handleSettingsValue(c telebot.Context, text string) error {
+	chatID := c.Chat().ID
+
+	if !hasRole(c, RoleEditor) {
+		clearChatState(chatID)
+		return c.Send("⛔ Недостаточно прав для изменения настроек")
+	}
+
+	hour, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil || hour < 0 || hour > 23 {
+		return c.Send("❌ Введите час от 0 до 23")
+	}
+
+	settings, err := getChatSettings(chatID)
+	if err != nil {
+		log.Println("Ошибка получения настроек:", err)
+		clearChatState(chatID)
+		return c.Send("❌ Ошибка")
+	}
+
+	settings.NotifyHour = hour
+	if err := saveChatSettings(settings); err != nil {
+		log.Println("Ошибка сохранения настроек:", err)
+		clearChatState(chatID)
+		return c.Send("❌ Ошибка при сохранении")
+	}
+
+	clearChatState(chatID)
+	return c.Send(fmt.Sprintf("✅ Время уведомлений установлено на %02d:00", hour))
+}
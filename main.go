@@ -1,8 +1,12 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"database/sql"
+	"encoding/csv"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strconv"
@@ -11,23 +15,64 @@ import (
 
 	"github.com/joho/godotenv"
 
+	"github.com/SokolovskiyIlya/GIDbdBot/notify"
 	_ "github.com/mattn/go-sqlite3"
 	"gopkg.in/telebot.v3"
 )
 
 type Employee struct {
-	ID            int
-	Name          string
-	Birthday      time.Time
-	ChatID        int64
-	LastNotifyDay int
+	ID       int
+	Name     string
+	Birthday time.Time
+	ChatID   int64
 }
 
-var (
-	db             *sql.DB
-	lastShownLists = make(map[int64][]Employee)
+// ChatSettings holds a chat's notification preferences: which lead-day
+// thresholds to notify on, the hour to deliver at, and the quiet-hours
+// window during which delivery is deferred until NotifyHour.
+type ChatSettings struct {
+	ChatID     int64
+	Thresholds []int
+	NotifyHour int
+	QuietStart int
+	QuietEnd   int
+}
+
+var defaultThresholds = []int{14, 7, 1, 0}
+
+const (
+	defaultNotifyHour = 9
+	defaultQuietStart = 22
+	defaultQuietEnd   = 8
+)
+
+// availableThresholds are the lead-day options offered in /settings.
+var availableThresholds = []int{30, 14, 7, 3, 1, 0}
+
+// Roles, from least to most privileged. Owners can grant/revoke roles,
+// editors can add/remove/import employees and change settings, viewers can
+// only read (/list, /notify, /export).
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
 )
 
+func roleRank(role string) int {
+	switch role {
+	case RoleOwner:
+		return 3
+	case RoleEditor:
+		return 2
+	case RoleViewer:
+		return 1
+	default:
+		return 0
+	}
+}
+
+var db *sql.DB
+
 func main() {
 	// Загружаем .env
 	if err := godotenv.Load(); err != nil {
@@ -41,6 +86,10 @@ func main() {
 	}
 	defer db.Close()
 
+	if err := notify.Init(db); err != nil {
+		log.Fatal("Ошибка инициализации таблицы уведомлений:", err)
+	}
+
 	botToken := os.Getenv("TELEGRAM_BOT_TOKEN")
 	if botToken == "" {
 		log.Fatal("Токен бота не указан")
@@ -57,13 +106,26 @@ func main() {
 	}
 
 	bot.Handle("/start", startHandler)
-	bot.Handle("/add", addHandler)
-	bot.Handle("/remove", removeHandler)
-	bot.Handle("/list", listHandler)
-	bot.Handle("/notify", notifyHandler)
+	bot.Handle("/add", addHandler, requireRole(RoleEditor))
+	bot.Handle("/remove", removeHandler, requireRole(RoleEditor))
+	bot.Handle("/list", listHandler, requireRole(RoleViewer))
+	bot.Handle("/notify", notifyHandler, requireRole(RoleViewer))
+	bot.Handle("/settings", settingsHandler, requireRole(RoleEditor))
+	bot.Handle("/export", exportHandler, requireRole(RoleViewer))
+	bot.Handle("/import", importHandler, requireRole(RoleEditor))
+	bot.Handle("/grant", grantHandler, requireRole(RoleOwner))
+	bot.Handle("/revoke", revokeHandler, requireRole(RoleOwner))
+	bot.Handle("/edit", editHandler, requireRole(RoleEditor))
+	bot.Handle("/cancel", cancelHandler)
+	bot.Handle(&btnToggleThreshold, toggleThresholdCallback)
+	bot.Handle(&btnShiftHour, shiftHourCallback)
+	bot.Handle(&btnCustomHour, customHourCallback)
+	bot.Handle(&btnEmployeePage, employeePageCallback)
+	bot.Handle(telebot.OnDocument, importDocumentHandler)
 	bot.Handle(telebot.OnText, textHandler)
 
 	go startDailyBirthdayChecker(bot)
+	go startStateCleanup()
 
 	log.Println("Бот запущен...")
 	bot.Start()
@@ -85,16 +147,169 @@ func initDB() error {
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		name TEXT NOT NULL,
 		birthday DATE NOT NULL,
-		chat_id INTEGER NOT NULL,
-		last_notify_day INTEGER DEFAULT -1
+		chat_id INTEGER NOT NULL
 	);
 	CREATE TABLE IF NOT EXISTS active_chats (
 		chat_id INTEGER PRIMARY KEY,
 		last_active DATE NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS chat_settings (
+		chat_id INTEGER PRIMARY KEY,
+		thresholds TEXT NOT NULL DEFAULT '14,7,1,0',
+		notify_hour INTEGER NOT NULL DEFAULT 9,
+		quiet_start INTEGER NOT NULL DEFAULT 22,
+		quiet_end INTEGER NOT NULL DEFAULT 8
+	);
+	CREATE TABLE IF NOT EXISTS chat_admins (
+		chat_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		role TEXT NOT NULL,
+		PRIMARY KEY (chat_id, user_id)
+	);
+	CREATE TABLE IF NOT EXISTS chat_state (
+		chat_id INTEGER PRIMARY KEY,
+		state TEXT NOT NULL,
+		payload_json TEXT NOT NULL DEFAULT '{}',
+		updated_at TIMESTAMP NOT NULL
 	)`)
 	return err
 }
 
+// requireRole returns middleware that rejects the update unless the sender
+// holds at least minRole in the chat it was sent from. The first person to
+// interact with a chat that has no chat_admins rows yet is made its owner,
+// so existing single-admin chats keep working without an explicit /grant.
+func requireRole(minRole string) telebot.MiddlewareFunc {
+	return func(next telebot.HandlerFunc) telebot.HandlerFunc {
+		return func(c telebot.Context) error {
+			chatID := c.Chat().ID
+			userID := c.Sender().ID
+
+			if err := ensureChatOwner(chatID, userID); err != nil {
+				log.Println("Ошибка назначения владельца чата:", err)
+			}
+
+			role, err := getRole(chatID, userID)
+			if err != nil {
+				log.Println("Ошибка проверки роли:", err)
+				return c.Send("❌ Ошибка проверки прав доступа")
+			}
+
+			if roleRank(role) < roleRank(minRole) {
+				return c.Send("⛔ Недостаточно прав для этой команды")
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// hasRole is the non-middleware equivalent of requireRole, for shared entry
+// points (textHandler, callbacks) that only need an access check for part
+// of what they do.
+func hasRole(c telebot.Context, minRole string) bool {
+	chatID := c.Chat().ID
+	userID := c.Sender().ID
+
+	if err := ensureChatOwner(chatID, userID); err != nil {
+		log.Println("Ошибка назначения владельца чата:", err)
+	}
+
+	role, err := getRole(chatID, userID)
+	if err != nil {
+		log.Println("Ошибка проверки роли:", err)
+		return false
+	}
+	return roleRank(role) >= roleRank(minRole)
+}
+
+func ensureChatOwner(chatID, userID int64) error {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM chat_admins WHERE chat_id = ?", chatID).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return setRole(chatID, userID, RoleOwner)
+}
+
+func getRole(chatID, userID int64) (string, error) {
+	var role string
+	err := db.QueryRow("SELECT role FROM chat_admins WHERE chat_id = ? AND user_id = ?", chatID, userID).Scan(&role)
+	switch err {
+	case nil:
+		return role, nil
+	case sql.ErrNoRows:
+		return RoleViewer, nil
+	default:
+		return "", err
+	}
+}
+
+func setRole(chatID, userID int64, role string) error {
+	_, err := db.Exec(
+		`INSERT INTO chat_admins (chat_id, user_id, role) VALUES (?, ?, ?)
+		ON CONFLICT(chat_id, user_id) DO UPDATE SET role = excluded.role`,
+		chatID, userID, role,
+	)
+	return err
+}
+
+func removeRole(chatID, userID int64) error {
+	_, err := db.Exec("DELETE FROM chat_admins WHERE chat_id = ? AND user_id = ?", chatID, userID)
+	return err
+}
+
+func grantHandler(c telebot.Context) error {
+	chatID := c.Chat().ID
+	updateActiveChat(chatID)
+
+	args := c.Args()
+	if len(args) < 2 {
+		return c.Send("Использование: /grant <user_id> <owner|editor|viewer>")
+	}
+
+	userID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return c.Send("❌ Некорректный user_id")
+	}
+
+	role := strings.ToLower(args[1])
+	if roleRank(role) == 0 {
+		return c.Send("❌ Роль должна быть owner, editor или viewer")
+	}
+
+	if err := setRole(chatID, userID, role); err != nil {
+		log.Println("Ошибка назначения роли:", err)
+		return c.Send("❌ Ошибка при назначении роли")
+	}
+
+	return c.Send(fmt.Sprintf("✅ Пользователю %d назначена роль %s", userID, role))
+}
+
+func revokeHandler(c telebot.Context) error {
+	chatID := c.Chat().ID
+	updateActiveChat(chatID)
+
+	args := c.Args()
+	if len(args) < 1 {
+		return c.Send("Использование: /revoke <user_id>")
+	}
+
+	userID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return c.Send("❌ Некорректный user_id")
+	}
+
+	if err := removeRole(chatID, userID); err != nil {
+		log.Println("Ошибка отзыва роли:", err)
+		return c.Send("❌ Ошибка при отзыве роли")
+	}
+
+	return c.Send(fmt.Sprintf("✅ Права пользователя %d отозваны", userID))
+}
+
 func startHandler(c telebot.Context) error {
 	updateActiveChat(c.Chat().ID)
 	return c.Send(`📅 Бот для учета дней рождения
@@ -102,11 +317,24 @@ func startHandler(c telebot.Context) error {
 /add - добавить сотрудника
 /remove - удалить сотрудника
 /list - список всех сотрудников
-/notify - отправить уведомления вручную`)
+/notify - отправить уведомления вручную
+/settings - настроить пороги и время уведомлений
+/export - выгрузить сотрудников в CSV и vCard
+/import - загрузить сотрудников из CSV или vCard
+/grant - выдать роль пользователю (только владелец)
+/revoke - отозвать роль пользователя (только владелец)
+/edit - изменить имя или дату рождения сотрудника
+/cancel - отменить текущее действие`)
 }
 
 func addHandler(c telebot.Context) error {
-	updateActiveChat(c.Chat().ID)
+	chatID := c.Chat().ID
+	updateActiveChat(chatID)
+
+	if err := setChatState(chatID, StateAwaitingAdd, struct{}{}); err != nil {
+		log.Println("Ошибка сохранения состояния:", err)
+	}
+
 	return c.Send("Введите данные сотрудника в формате:\nИмя Фамилия ДД.ММ.ГГГГ\n\nПример: Иван Иванов 15.05.1990")
 }
 
@@ -114,30 +342,35 @@ func removeHandler(c telebot.Context) error {
 	chatID := c.Chat().ID
 	updateActiveChat(chatID)
 
-	employees, err := getAllEmployees()
+	employees, err := getEmployeesByChat(chatID)
 	if err != nil {
 		log.Println("Ошибка получения списка:", err)
 		return c.Send("❌ Ошибка при получении списка сотрудников")
 	}
 
-	if len(employees) == 0 {
-		return c.Send("ℹ️ Список сотрудников пуст")
-	}
+	text, markup := renderEmployeePage(employees, 0, employeePageModeRemove)
+	return c.Send(text, markup)
+}
 
-	var message strings.Builder
-	message.WriteString("Выберите сотрудника для удаления:\n")
-	for i, emp := range employees {
-		message.WriteString(fmt.Sprintf("%d. %s (%s)\n", i+1, emp.Name, emp.Birthday.Format("02.01.2006")))
+func listHandler(c telebot.Context) error {
+	chatID := c.Chat().ID
+	updateActiveChat(chatID)
+
+	employees, err := getEmployeesByChat(chatID)
+	if err != nil {
+		log.Println("Ошибка получения списка:", err)
+		return c.Send("❌ Ошибка при получении списка сотрудников")
 	}
-	message.WriteString("\nОтправьте номер сотрудника для удаления")
 
-	lastShownLists[chatID] = employees
-	return c.Send(message.String())
+	text, markup := renderEmployeePage(employees, 0, employeePageModeList)
+	return c.Send(text, markup)
 }
 
-func listHandler(c telebot.Context) error {
-	updateActiveChat(c.Chat().ID)
-	employees, err := getAllEmployees()
+func exportHandler(c telebot.Context) error {
+	chatID := c.Chat().ID
+	updateActiveChat(chatID)
+
+	employees, err := getEmployeesByChat(chatID)
 	if err != nil {
 		log.Println("Ошибка получения списка:", err)
 		return c.Send("❌ Ошибка при получении списка сотрудников")
@@ -147,135 +380,703 @@ func listHandler(c telebot.Context) error {
 		return c.Send("ℹ️ Список сотрудников пуст")
 	}
 
-	var message strings.Builder
-	message.WriteString("📋 Общий список сотрудников:\n\n")
-	for _, emp := range employees {
-		message.WriteString(fmt.Sprintf("• %s - %s\n", emp.Name, emp.Birthday.Format("02.01.2006")))
+	csvData, err := buildEmployeesCSV(employees)
+	if err != nil {
+		log.Println("Ошибка формирования CSV:", err)
+		return c.Send("❌ Ошибка при формировании файла")
 	}
 
-	return c.Send(message.String())
+	if err := c.Send(&telebot.Document{
+		File:     telebot.FromReader(bytes.NewReader(csvData)),
+		FileName: "employees.csv",
+		MIME:     "text/csv",
+	}); err != nil {
+		log.Println("Ошибка отправки CSV:", err)
+		return c.Send("❌ Ошибка при отправке файла")
+	}
+
+	vcardData := buildEmployeesVCard(employees)
+	if err := c.Send(&telebot.Document{
+		File:     telebot.FromReader(bytes.NewReader(vcardData)),
+		FileName: "employees.vcf",
+		MIME:     "text/vcard",
+	}); err != nil {
+		log.Println("Ошибка отправки vCard:", err)
+	}
+
+	return nil
 }
 
-func notifyHandler(c telebot.Context) error {
+func importHandler(c telebot.Context) error {
 	updateActiveChat(c.Chat().ID)
+	return c.Send("Отправьте файл CSV (\"Имя,ДД.ММ.ГГГГ\" или \"Имя,ГГГГ-ММ-ДД\") или vCard (.vcf) с сотрудниками для импорта")
+}
 
-	employees, err := getAllEmployees()
+// importDocumentHandler treats any uploaded document as an import request,
+// dispatching on file extension to the CSV or vCard parser.
+func importDocumentHandler(c telebot.Context) error {
+	chatID := c.Chat().ID
+	updateActiveChat(chatID)
+
+	doc := c.Message().Document
+	if doc == nil {
+		return nil
+	}
+
+	if !hasRole(c, RoleEditor) {
+		return c.Send("⛔ Недостаточно прав для импорта сотрудников")
+	}
+
+	reader, err := c.Bot().File(&doc.File)
 	if err != nil {
-		log.Println("Ошибка получения списка:", err)
-		return c.Send("❌ Ошибка при получении списка сотрудников")
+		log.Println("Ошибка скачивания файла:", err)
+		return c.Send("❌ Не удалось скачать файл")
 	}
+	defer reader.Close()
 
-	activeChats, err := getAllActiveChats()
+	data, err := io.ReadAll(reader)
 	if err != nil {
-		log.Println("Ошибка получения активных чатов:", err)
-		return c.Send("❌ Ошибка при получении списка чатов")
+		log.Println("Ошибка чтения файла:", err)
+		return c.Send("❌ Не удалось прочитать файл")
 	}
 
-	hasNotifications := false
+	var (
+		employees   []Employee
+		parseErrors int
+	)
+	if strings.HasSuffix(strings.ToLower(doc.FileName), ".vcf") {
+		employees, parseErrors = parseVCard(data, chatID)
+	} else {
+		employees, parseErrors = parseCSV(data, chatID)
+	}
+
+	added, skipped, err := importEmployees(employees)
+	if err != nil {
+		log.Println("Ошибка импорта:", err)
+		return c.Send("❌ Ошибка при импорте сотрудников")
+	}
+
+	return c.Send(fmt.Sprintf("✅ Импорт завершён: добавлено %d, пропущено %d", added, skipped+parseErrors))
+}
+
+func buildEmployeesCSV(employees []Employee) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	for _, emp := range employees {
+		if err := w.Write([]string{emp.Name, emp.Birthday.Format("02.01.2006")}); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
 
+func buildEmployeesVCard(employees []Employee) []byte {
+	var buf bytes.Buffer
 	for _, emp := range employees {
-		daysUntil := daysUntilBirthday(emp.Birthday)
+		buf.WriteString("BEGIN:VCARD\r\n")
+		buf.WriteString("VERSION:3.0\r\n")
+		fmt.Fprintf(&buf, "FN:%s\r\n", emp.Name)
+		fmt.Fprintf(&buf, "BDAY:%s\r\n", emp.Birthday.Format("20060102"))
+		buf.WriteString("END:VCARD\r\n")
+	}
+	return buf.Bytes()
+}
+
+// parseCSV reads "Имя,Дата" rows, tolerating both ДД.ММ.ГГГГ and ISO
+// YYYY-MM-DD dates. It returns the rows that parsed cleanly and a count of
+// rows skipped due to malformed data.
+func parseCSV(data []byte, chatID int64) ([]Employee, int) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+
+	var employees []Employee
+	skipped := 0
+
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			skipped++
+			continue
+		}
+		if len(record) < 2 {
+			skipped++
+			continue
+		}
 
-		if daysUntil == 14 || daysUntil == 7 || daysUntil == 1 || daysUntil == 0 {
-			msg := createNotificationMessage(emp.Name, daysUntil, emp.Birthday)
+		name := strings.TrimSpace(record[0])
+		birthday, err := parseBirthday(record[1])
+		if name == "" || err != nil {
+			skipped++
+			continue
+		}
 
-			for _, chatID := range activeChats {
-				if _, err := c.Bot().Send(telebot.ChatID(chatID), msg); err != nil {
-					log.Printf("Ошибка отправки в чат %d: %v", chatID, err)
-				}
+		employees = append(employees, Employee{Name: name, Birthday: birthday, ChatID: chatID})
+	}
+
+	return employees, skipped
+}
+
+// parseVCard reads BEGIN:VCARD/END:VCARD blocks, pulling FN and BDAY
+// (including vCard's partial-date --MM-DD for unknown years).
+func parseVCard(data []byte, chatID int64) ([]Employee, int) {
+	var employees []Employee
+	skipped := 0
+
+	var name, bday string
+	inCard := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		upper := strings.ToUpper(line)
+
+		switch {
+		case upper == "BEGIN:VCARD":
+			inCard = true
+			name, bday = "", ""
+		case upper == "END:VCARD":
+			if !inCard {
+				continue
 			}
-			hasNotifications = true
+			inCard = false
+
+			birthday, err := parseBirthday(bday)
+			if name == "" || err != nil {
+				skipped++
+				continue
+			}
+			employees = append(employees, Employee{Name: name, Birthday: birthday, ChatID: chatID})
+		case strings.HasPrefix(upper, "FN:"):
+			name = strings.TrimSpace(line[len("FN:"):])
+		case strings.HasPrefix(upper, "BDAY:"):
+			bday = strings.TrimSpace(line[len("BDAY:"):])
+		}
+	}
+
+	return employees, skipped
+}
+
+// parseBirthday accepts ДД.ММ.ГГГГ, ISO YYYY-MM-DD, vCard's unpunctuated
+// YYYYMMDD (what buildEmployeesVCard writes as BDAY), and vCard's partial
+// --MM-DD (placed in year 1900 since the year is unknown).
+func parseBirthday(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+
+	if strings.HasPrefix(s, "--") {
+		t, err := time.Parse("01-02", s[2:])
+		if err != nil {
+			return time.Time{}, err
 		}
+		return time.Date(1900, t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
 	}
 
-	if !hasNotifications {
-		return c.Send("ℹ️ В ближайшие 14 дней дней рождения нет")
+	if t, err := time.Parse("02.01.2006", s); err == nil {
+		return t, nil
+	}
+
+	if t, err := time.Parse("20060102", s); err == nil {
+		return t, nil
+	}
+
+	return time.Parse("2006-01-02", s)
+}
+
+// notifyHandler is a thin wrapper around the notify pipeline: it forces a
+// CheckNext + Mailing pass synchronously, independent of the daily checker.
+func notifyHandler(c telebot.Context) error {
+	updateActiveChat(c.Chat().ID)
+
+	due, err := pendingNotifications(time.Now())
+	if err != nil {
+		log.Println("Ошибка проверки уведомлений:", err)
+		return c.Send("❌ Ошибка при проверке уведомлений")
+	}
+
+	if len(due) == 0 {
+		return c.Send("ℹ️ В ближайшее время дней рождения нет")
+	}
+
+	if err := notify.Mailing(c.Bot(), due, notificationMessage); err != nil {
+		log.Println("Ошибка рассылки уведомлений:", err)
+		return c.Send("⚠️ Часть уведомлений не отправлена, подробности в логах")
 	}
 
 	return c.Send("✅ Уведомления отправлены во все активные чаты")
 }
 
-func textHandler(c telebot.Context) error {
-	text := c.Text()
+// pendingNotifications gathers employees and per-chat settings and asks the
+// notify package which notifications are due at `now`.
+func pendingNotifications(now time.Time) ([]notify.Notification, error) {
+	employees, err := getAllEmployees()
+	if err != nil {
+		return nil, err
+	}
+
+	activeChats, err := getAllActiveChats()
+	if err != nil {
+		return nil, err
+	}
+
+	settingsByChat := make(map[int64]notify.ChatSettings, len(activeChats))
+	for _, chatID := range activeChats {
+		settings, err := getChatSettings(chatID)
+		if err != nil {
+			log.Printf("Ошибка получения настроек чата %d: %v", chatID, err)
+			continue
+		}
+		settingsByChat[chatID] = notify.ChatSettings{ChatID: chatID, Thresholds: settings.Thresholds}
+	}
+
+	return notify.CheckNext(now, toNotifyEmployees(employees), settingsByChat)
+}
+
+func toNotifyEmployees(employees []Employee) []notify.Employee {
+	result := make([]notify.Employee, len(employees))
+	for i, e := range employees {
+		result[i] = notify.Employee{ID: e.ID, Name: e.Name, Birthday: e.Birthday, ChatID: e.ChatID}
+	}
+	return result
+}
+
+func notificationMessage(n notify.Notification) string {
+	if n.Type == notify.TypeChangedDate {
+		return fmt.Sprintf("✏️ Дата рождения %s изменена на %s", n.Name, n.Birthday.Format("02.01.2006"))
+	}
+	return createNotificationMessage(n.Name, n.DaysUntil, n.Birthday)
+}
+
+// notifyBirthdayChanged sends a TypeChangedDate notification to the chat
+// when an employee's birthday is edited, through the same send path (retry,
+// rate limiting) as the scheduled pipeline. Best-effort: a failure here
+// doesn't roll back the edit that already succeeded.
+func notifyBirthdayChanged(c telebot.Context, name string, chatID int64, employeeID int, birthday time.Time) {
+	n := notify.Notification{
+		Type:       notify.TypeChangedDate,
+		EmployeeID: employeeID,
+		ChatID:     chatID,
+		Name:       name,
+		Birthday:   birthday,
+	}
+	if err := notify.Mailing(c.Bot(), []notify.Notification{n}, notificationMessage); err != nil {
+		log.Println("Ошибка отправки уведомления об изменении даты рождения:", err)
+	}
+}
+
+var (
+	btnToggleThreshold = telebot.InlineButton{Unique: "toggle_threshold"}
+	btnShiftHour       = telebot.InlineButton{Unique: "shift_hour"}
+	btnCustomHour      = telebot.InlineButton{Unique: "custom_hour"}
+)
+
+func settingsHandler(c telebot.Context) error {
 	chatID := c.Chat().ID
 	updateActiveChat(chatID)
 
-	if employees, ok := lastShownLists[chatID]; ok {
-		num, err := strconv.Atoi(text)
-		if err != nil || num < 1 || num > len(employees) {
-			delete(lastShownLists, chatID)
-			return c.Send("❌ Неверный номер сотрудника")
-		}
+	settings, err := getChatSettings(chatID)
+	if err != nil {
+		log.Println("Ошибка получения настроек:", err)
+		return c.Send("❌ Ошибка при получении настроек")
+	}
 
-		employee := employees[num-1]
-		if err := deleteEmployee(employee.ID); err != nil {
-			log.Println("Ошибка удаления:", err)
-			delete(lastShownLists, chatID)
-			return c.Send("❌ Ошибка при удалении сотрудника")
-		}
+	return c.Send(settingsMessage(settings), renderSettingsKeyboard(settings))
+}
 
-		delete(lastShownLists, chatID)
-		return c.Send(fmt.Sprintf("✅ Сотрудник %s удален", employee.Name))
+func toggleThresholdCallback(c telebot.Context) error {
+	chatID := c.Chat().ID
+	if !hasRole(c, RoleEditor) {
+		return c.Respond(&telebot.CallbackResponse{Text: "⛔ Недостаточно прав"})
 	}
 
-	if !strings.HasPrefix(text, "/") {
-		parts := strings.Split(text, " ")
-		if len(parts) < 3 {
-			return c.Send("❌ Неверный формат. Используйте: Имя Фамилия ДД.ММ.ГГГГ")
-		}
+	day, err := strconv.Atoi(c.Callback().Data)
+	if err != nil {
+		return c.Respond(&telebot.CallbackResponse{Text: "❌ Некорректное значение"})
+	}
 
-		name := strings.Join(parts[:len(parts)-1], " ")
-		dateStr := parts[len(parts)-1]
+	settings, err := getChatSettings(chatID)
+	if err != nil {
+		log.Println("Ошибка получения настроек:", err)
+		return c.Respond(&telebot.CallbackResponse{Text: "❌ Ошибка"})
+	}
 
-		birthday, err := time.Parse("02.01.2006", dateStr)
-		if err != nil {
-			return c.Send("❌ Неверный формат даты. Используйте ДД.ММ.ГГГГ")
+	settings.Thresholds = toggleThreshold(settings.Thresholds, day)
+	if err := saveChatSettings(settings); err != nil {
+		log.Println("Ошибка сохранения настроек:", err)
+		return c.Respond(&telebot.CallbackResponse{Text: "❌ Ошибка"})
+	}
+
+	if err := c.Edit(settingsMessage(settings), renderSettingsKeyboard(settings)); err != nil {
+		log.Println("Ошибка обновления сообщения настроек:", err)
+	}
+	return c.Respond()
+}
+
+func shiftHourCallback(c telebot.Context) error {
+	chatID := c.Chat().ID
+	if !hasRole(c, RoleEditor) {
+		return c.Respond(&telebot.CallbackResponse{Text: "⛔ Недостаточно прав"})
+	}
+
+	delta, err := strconv.Atoi(c.Callback().Data)
+	if err != nil {
+		return c.Respond(&telebot.CallbackResponse{Text: "❌ Некорректное значение"})
+	}
+
+	settings, err := getChatSettings(chatID)
+	if err != nil {
+		log.Println("Ошибка получения настроек:", err)
+		return c.Respond(&telebot.CallbackResponse{Text: "❌ Ошибка"})
+	}
+
+	settings.NotifyHour = ((settings.NotifyHour+delta)%24 + 24) % 24
+	if err := saveChatSettings(settings); err != nil {
+		log.Println("Ошибка сохранения настроек:", err)
+		return c.Respond(&telebot.CallbackResponse{Text: "❌ Ошибка"})
+	}
+
+	if err := c.Edit(settingsMessage(settings), renderSettingsKeyboard(settings)); err != nil {
+		log.Println("Ошибка обновления сообщения настроек:", err)
+	}
+	return c.Respond()
+}
+
+func customHourCallback(c telebot.Context) error {
+	chatID := c.Chat().ID
+	if !hasRole(c, RoleEditor) {
+		return c.Respond(&telebot.CallbackResponse{Text: "⛔ Недостаточно прав"})
+	}
+
+	if err := setChatState(chatID, StateAwaitingSettingsVal, struct{}{}); err != nil {
+		log.Println("Ошибка сохранения состояния:", err)
+		return c.Respond(&telebot.CallbackResponse{Text: "❌ Ошибка"})
+	}
+
+	if err := c.Send("Введите час доставки уведомлений (0-23)"); err != nil {
+		log.Println("Ошибка отправки сообщения:", err)
+	}
+	return c.Respond()
+}
+
+func settingsMessage(settings ChatSettings) string {
+	var b strings.Builder
+	b.WriteString("⚙️ Настройки уведомлений\n\n")
+	b.WriteString("Пороги (за сколько дней напоминать), активные отмечены ✅:\n")
+	b.WriteString(fmt.Sprintf("Время отправки: %02d:00\n", settings.NotifyHour))
+	b.WriteString(fmt.Sprintf("Тихие часы: %02d:00–%02d:00\n", settings.QuietStart, settings.QuietEnd))
+	return b.String()
+}
+
+func renderSettingsKeyboard(settings ChatSettings) *telebot.ReplyMarkup {
+	markup := &telebot.ReplyMarkup{}
+
+	var rows []telebot.Row
+	var row []telebot.Btn
+	for _, day := range availableThresholds {
+		label := strconv.Itoa(day)
+		if containsInt(settings.Thresholds, day) {
+			label = "✅ " + label
+		}
+		btn := markup.Data(label, btnToggleThreshold.Unique, strconv.Itoa(day))
+		row = append(row, btn)
+		if len(row) == 3 {
+			rows = append(rows, markup.Row(row...))
+			row = nil
 		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, markup.Row(row...))
+	}
 
-		if err := addEmployee(name, birthday, chatID); err != nil {
-			log.Println("Ошибка добавления:", err)
-			return c.Send("❌ Ошибка при добавлении сотрудника")
+	rows = append(rows, markup.Row(
+		markup.Data("−1 час", btnShiftHour.Unique, "-1"),
+		markup.Data("+1 час", btnShiftHour.Unique, "1"),
+		markup.Data("✏️ Ввести час", btnCustomHour.Unique, ""),
+	))
+
+	markup.Inline(rows...)
+	return markup
+}
+
+func toggleThreshold(thresholds []int, day int) []int {
+	for i, d := range thresholds {
+		if d == day {
+			return append(thresholds[:i], thresholds[i+1:]...)
 		}
+	}
+	return append(append([]int{}, thresholds...), day)
+}
 
-		return c.Send(fmt.Sprintf("✅ Сотрудник %s добавлен (день рождения: %s)",
-			name, birthday.Format("02.01.2006")))
+func containsInt(xs []int, x int) bool {
+	for _, v := range xs {
+		if v == x {
+			return true
+		}
 	}
+	return false
+}
 
-	return nil
+func getChatSettings(chatID int64) (ChatSettings, error) {
+	row := db.QueryRow(
+		"SELECT thresholds, notify_hour, quiet_start, quiet_end FROM chat_settings WHERE chat_id = ?",
+		chatID,
+	)
+
+	var thresholdsStr string
+	settings := ChatSettings{ChatID: chatID}
+	switch err := row.Scan(&thresholdsStr, &settings.NotifyHour, &settings.QuietStart, &settings.QuietEnd); err {
+	case nil:
+		settings.Thresholds = parseThresholds(thresholdsStr)
+		return settings, nil
+	case sql.ErrNoRows:
+		return ChatSettings{
+			ChatID:     chatID,
+			Thresholds: append([]int{}, defaultThresholds...),
+			NotifyHour: defaultNotifyHour,
+			QuietStart: defaultQuietStart,
+			QuietEnd:   defaultQuietEnd,
+		}, nil
+	default:
+		return ChatSettings{}, err
+	}
 }
 
-func addEmployee(name string, birthday time.Time, chatID int64) error {
+func saveChatSettings(settings ChatSettings) error {
 	_, err := db.Exec(
+		`INSERT INTO chat_settings (chat_id, thresholds, notify_hour, quiet_start, quiet_end)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(chat_id) DO UPDATE SET
+			thresholds = excluded.thresholds,
+			notify_hour = excluded.notify_hour,
+			quiet_start = excluded.quiet_start,
+			quiet_end = excluded.quiet_end`,
+		settings.ChatID, thresholdsString(settings.Thresholds), settings.NotifyHour, settings.QuietStart, settings.QuietEnd,
+	)
+	return err
+}
+
+func parseThresholds(s string) []int {
+	parts := strings.Split(s, ",")
+	thresholds := make([]int, 0, len(parts))
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if day, err := strconv.Atoi(p); err == nil {
+			thresholds = append(thresholds, day)
+		}
+	}
+	return thresholds
+}
+
+func thresholdsString(thresholds []int) string {
+	parts := make([]string, len(thresholds))
+	for i, d := range thresholds {
+		parts[i] = strconv.Itoa(d)
+	}
+	return strings.Join(parts, ",")
+}
+
+func inQuietHours(now time.Time, settings ChatSettings) bool {
+	hour := now.Hour()
+	if settings.QuietStart == settings.QuietEnd {
+		return false
+	}
+	if settings.QuietStart < settings.QuietEnd {
+		return hour >= settings.QuietStart && hour < settings.QuietEnd
+	}
+	// Wraps past midnight, e.g. 22:00–08:00.
+	return hour >= settings.QuietStart || hour < settings.QuietEnd
+}
+
+// nextNotifyMoment returns when a chat that can't be sent to right now (in
+// quiet hours, or before its NotifyHour today) may next receive a
+// notification: its NotifyHour today, or tomorrow if that's already passed.
+// Callers should only call this for such chats — a chat that's already
+// sendable has no future moment to report here.
+func nextNotifyMoment(now time.Time, settings ChatSettings) time.Time {
+	candidate := time.Date(now.Year(), now.Month(), now.Day(), settings.NotifyHour, 0, 0, 0, now.Location())
+	if !candidate.After(now) {
+		candidate = candidate.AddDate(0, 0, 1)
+	}
+	return candidate
+}
+
+// textHandler dispatches free text to whichever conversation state the
+// chat is currently in. Every multi-step flow (/add, /edit, /settings'
+// custom hour) parks its chat in a state via setChatState and
+// resumes here on the next message, instead of the old lastShownLists map.
+func textHandler(c telebot.Context) error {
+	text := c.Text()
+	chatID := c.Chat().ID
+	updateActiveChat(chatID)
+
+	if strings.HasPrefix(text, "/") {
+		return nil
+	}
+
+	state, err := getChatState(chatID)
+	if err != nil {
+		log.Println("Ошибка получения состояния чата:", err)
+		return c.Send("❌ Ошибка")
+	}
+
+	switch state.State {
+	case StateAwaitingAdd:
+		return handleAddText(c, text)
+	case StateAwaitingEditField:
+		return handleEditField(c, state, text)
+	case StateAwaitingEditValue:
+		return handleEditValue(c, state, text)
+	case StateAwaitingSettingsVal:
+		return handleSettingsValue(c, text)
+	default:
+		return c.Send("ℹ️ Используйте /add, /remove, /edit или /settings")
+	}
+}
+
+// addEmployee takes a *sql.Tx, not *sql.DB, so bulk inserts (see
+// importEmployees) run inside a single transaction instead of hammering
+// SQLite with one implicit transaction per row.
+func addEmployee(tx *sql.Tx, name string, birthday time.Time, chatID int64) error {
+	_, err := tx.Exec(
 		"INSERT INTO employees (name, birthday, chat_id) VALUES (?, ?, ?)",
 		name, birthday.Format("2006-01-02"), chatID,
 	)
 	return err
 }
 
+// addEmployeeTx wraps a single addEmployee call in its own transaction, for
+// the interactive /add flow where there's only one row to insert.
+func addEmployeeTx(name string, birthday time.Time, chatID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := addEmployee(tx, name, birthday, chatID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// importEmployees inserts employees in a single transaction, skipping (and
+// counting) rows that fail to insert instead of aborting the whole batch.
+func importEmployees(employees []Employee) (added, skipped int, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	for _, emp := range employees {
+		if err := addEmployee(tx, emp.Name, emp.Birthday, emp.ChatID); err != nil {
+			log.Printf("import: пропуск %s: %v", emp.Name, err)
+			skipped++
+			continue
+		}
+		added++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return added, skipped, err
+	}
+	return added, skipped, nil
+}
+
 func deleteEmployee(id int) error {
 	_, err := db.Exec("DELETE FROM employees WHERE id = ?", id)
 	return err
 }
 
+func getEmployeeByID(id int) (*Employee, error) {
+	rows, err := db.Query("SELECT id, name, date(birthday) as birthday, chat_id FROM employees WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	employees, err := scanEmployees(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(employees) == 0 {
+		return nil, nil
+	}
+	return &employees[0], nil
+}
+
+// getChatEmployee looks up an employee by ID and confirms it belongs to
+// chatID, returning (nil, nil) otherwise. IDs handed back to a chat through
+// inline-keyboard callback data or FSM payloads must be re-checked against
+// the chat they're used in so one chat can't act on another chat's
+// employees by replaying or guessing an ID.
+func getChatEmployee(chatID int64, id int) (*Employee, error) {
+	employee, err := getEmployeeByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if employee == nil || employee.ChatID != chatID {
+		return nil, nil
+	}
+	return employee, nil
+}
+
+func updateEmployeeName(id int, name string) error {
+	_, err := db.Exec("UPDATE employees SET name = ? WHERE id = ?", name, id)
+	return err
+}
+
+func updateEmployeeBirthday(id int, birthday time.Time) error {
+	_, err := db.Exec("UPDATE employees SET birthday = ? WHERE id = ?", birthday.Format("2006-01-02"), id)
+	return err
+}
+
+// getEmployeesByChat returns only the employees belonging to chatID. This is
+// the scoping every handler must use so chats don't see each other's data;
+// getAllEmployees exists solely for cross-chat jobs like the daily checker.
+func getEmployeesByChat(chatID int64) ([]Employee, error) {
+	rows, err := db.Query(
+		"SELECT id, name, date(birthday) as birthday, chat_id FROM employees WHERE chat_id = ? ORDER BY name",
+		chatID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanEmployees(rows)
+}
+
 func getAllEmployees() ([]Employee, error) {
-	rows, err := db.Query("SELECT id, name, date(birthday) as birthday, chat_id, last_notify_day FROM employees ORDER BY name")
+	rows, err := db.Query("SELECT id, name, date(birthday) as birthday, chat_id FROM employees ORDER BY name")
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	return scanEmployees(rows)
+}
+
+func scanEmployees(rows *sql.Rows) ([]Employee, error) {
 	var employees []Employee
 	for rows.Next() {
 		var emp Employee
 		var dateStr string
-		if err := rows.Scan(&emp.ID, &emp.Name, &dateStr, &emp.ChatID, &emp.LastNotifyDay); err != nil {
+		if err := rows.Scan(&emp.ID, &emp.Name, &dateStr, &emp.ChatID); err != nil {
 			return nil, err
 		}
 
-		emp.Birthday, err = time.Parse("2006-01-02", strings.Split(dateStr, "T")[0])
+		birthday, err := time.Parse("2006-01-02", strings.Split(dateStr, "T")[0])
 		if err != nil {
 			return nil, fmt.Errorf("ошибка парсинга даты '%s': %v", dateStr, err)
 		}
+		emp.Birthday = birthday
 
 		employees = append(employees, emp)
 	}
@@ -314,81 +1115,72 @@ func startDailyBirthdayChecker(bot *telebot.Bot) {
 		location = time.UTC
 	}
 
-	// Первая проверка сразу при запуске
-	checkAndNotifyBirthdays(bot, location)
-
-	ticker := time.NewTicker(10 * time.Minute)
-	defer ticker.Stop()
-
+	// Проверяем сразу при запуске, а дальше спим до следующего события:
+	// либо до ближайшего NotifyHour отложенного чата, либо до полуночи, когда
+	// у сотрудников меняется количество дней до дня рождения.
 	for {
-		select {
-		case <-ticker.C:
-			checkAndNotifyBirthdays(bot, location)
+		next := checkAndNotifyBirthdays(bot, location)
+		sleep := time.Until(next)
+		if sleep <= 0 {
+			sleep = time.Minute
 		}
+		time.Sleep(sleep)
 	}
 }
 
-func checkAndNotifyBirthdays(bot *telebot.Bot, location *time.Location) {
+func checkAndNotifyBirthdays(bot *telebot.Bot, location *time.Location) time.Time {
 	now := time.Now().In(location)
 	log.Printf("Проверка дней рождения в %s", now.Format("2006-01-02 15:04:05 MST"))
 
+	nextCheck := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, location)
+
 	employees, err := getAllEmployees()
 	if err != nil {
 		log.Println("Ошибка проверки дней рождения:", err)
-		return
+		return nextCheck
 	}
 
 	activeChats, err := getAllActiveChats()
 	if err != nil {
 		log.Println("Ошибка получения списка чатов:", err)
-		return
+		return nextCheck
 	}
 
-	for _, emp := range employees {
-		daysUntil := daysUntilBirthday(emp.Birthday)
-		log.Printf("Проверка %s: дней до ДР - %d (последнее уведомление было за %d дней)",
-			emp.Name, daysUntil, emp.LastNotifyDay)
-
-		if (daysUntil == 14 || daysUntil == 7 || daysUntil == 1 || daysUntil == 0) &&
-			emp.LastNotifyDay != daysUntil {
-			msg := createNotificationMessage(emp.Name, daysUntil, emp.Birthday)
-			log.Printf("Отправка уведомления: %s", msg)
-
-			for _, chatID := range activeChats {
-				if _, err := bot.Send(telebot.ChatID(chatID), msg); err != nil {
-					log.Printf("Ошибка отправки в чат %d: %v", chatID, err)
-				} else {
-					log.Printf("Уведомление отправлено в чат %d", chatID)
-				}
-			}
+	// Чаты, для которых сейчас не тихие часы и уже наступил их NotifyHour,
+	// участвуют в рассылке сейчас и не влияют на nextCheck — им уже можно
+	// отправлять. Остальные сдвигают nextCheck на свой ближайший NotifyHour,
+	// а не на "сейчас".
+	sendable := make(map[int64]notify.ChatSettings, len(activeChats))
+	for _, chatID := range activeChats {
+		settings, err := getChatSettings(chatID)
+		if err != nil {
+			log.Printf("Ошибка получения настроек чата %d: %v", chatID, err)
+			continue
+		}
 
-			if err := updateLastNotifyDay(emp.ID, daysUntil); err != nil {
-				log.Println("Ошибка обновления дня уведомления:", err)
+		if inQuietHours(now, settings) || now.Hour() < settings.NotifyHour {
+			if moment := nextNotifyMoment(now, settings); moment.Before(nextCheck) {
+				nextCheck = moment
 			}
+			continue
 		}
-	}
-}
 
-func daysUntilBirthday(birthday time.Time) int {
-	now := time.Now().UTC()
-	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
-
-	// Приводим birthday к UTC и игнорируем время (оставляем только дату)
-	birthdayUTC := time.Date(birthday.Year(), birthday.Month(), birthday.Day(), 0, 0, 0, 0, time.UTC)
-	birthdayThisYear := time.Date(now.Year(), birthdayUTC.Month(), birthdayUTC.Day(), 0, 0, 0, 0, time.UTC)
-
-	if today.After(birthdayThisYear) {
-		birthdayThisYear = birthdayThisYear.AddDate(1, 0, 0)
+		sendable[chatID] = notify.ChatSettings{ChatID: chatID, Thresholds: settings.Thresholds}
 	}
 
-	days := int(birthdayThisYear.Sub(today).Hours() / 24)
+	due, err := notify.CheckNext(now, toNotifyEmployees(employees), sendable)
+	if err != nil {
+		log.Println("Ошибка проверки уведомлений:", err)
+		return nextCheck
+	}
 
-	// Если день рождения сегодня, но время еще не наступило (UTC)
-	if days < 0 {
-		days = 0
+	if len(due) > 0 {
+		if err := notify.Mailing(bot, due, notificationMessage); err != nil {
+			log.Println("Ошибка рассылки уведомлений:", err)
+		}
 	}
 
-	return days
+	return nextCheck
 }
 
 func createNotificationMessage(name string, daysUntil int, date time.Time) string {
@@ -402,11 +1194,6 @@ func createNotificationMessage(name string, daysUntil int, date time.Time) strin
 		date.Format("02.01.2006"))
 }
 
-func updateLastNotifyDay(employeeID int, day int) error {
-	_, err := db.Exec("UPDATE employees SET last_notify_day = ? WHERE id = ?", day, employeeID)
-	return err
-}
-
 func formatDays(days int) string {
 	lastDigit := days % 10
 	lastTwoDigits := days % 100
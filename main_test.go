@@ -0,0 +1,144 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestParseBirthday(t *testing.T) {
+	cases := []struct {
+		name    string
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{"ru", "15.05.1990", time.Date(1990, 5, 15, 0, 0, 0, 0, time.UTC), false},
+		{"iso", "1990-05-15", time.Date(1990, 5, 15, 0, 0, 0, 0, time.UTC), false},
+		{"vcard partial date", "--05-15", time.Date(1900, 5, 15, 0, 0, 0, 0, time.UTC), false},
+		{"padded", "  15.05.1990  ", time.Date(1990, 5, 15, 0, 0, 0, 0, time.UTC), false},
+		{"garbage", "not a date", time.Time{}, true},
+		{"empty", "", time.Time{}, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseBirthday(tc.in)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("parseBirthday(%q) = %v, want error", tc.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseBirthday(%q) unexpected error: %v", tc.in, err)
+			}
+			if !got.Equal(tc.want) {
+				t.Errorf("parseBirthday(%q) = %v, want %v", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseCSV(t *testing.T) {
+	data := []byte("Иван Иванов,15.05.1990\n" +
+		"Пётр Петров,1991-12-31\n" +
+		"Без даты\n" +
+		"Плохая Дата,не дата\n")
+
+	employees, skipped := parseCSV(data, 42)
+
+	if len(employees) != 2 {
+		t.Fatalf("got %d employees, want 2: %+v", len(employees), employees)
+	}
+	if skipped != 2 {
+		t.Errorf("got skipped=%d, want 2", skipped)
+	}
+
+	if employees[0].Name != "Иван Иванов" || !employees[0].Birthday.Equal(time.Date(1990, 5, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected first row: %+v", employees[0])
+	}
+	if employees[1].ChatID != 42 {
+		t.Errorf("got ChatID=%d, want 42", employees[1].ChatID)
+	}
+}
+
+func TestParseVCard(t *testing.T) {
+	data := []byte("BEGIN:VCARD\r\n" +
+		"VERSION:3.0\r\n" +
+		"FN:Иван Иванов\r\n" +
+		"BDAY:19900515\r\n" +
+		"END:VCARD\r\n" +
+		"BEGIN:VCARD\r\n" +
+		"FN:Без даты\r\n" +
+		"END:VCARD\r\n")
+
+	employees, skipped := parseVCard(data, 7)
+
+	if len(employees) != 1 {
+		t.Fatalf("got %d employees, want 1: %+v", len(employees), employees)
+	}
+	if skipped != 1 {
+		t.Errorf("got skipped=%d, want 1", skipped)
+	}
+	if employees[0].Name != "Иван Иванов" {
+		t.Errorf("got Name=%q, want %q", employees[0].Name, "Иван Иванов")
+	}
+	if employees[0].ChatID != 7 {
+		t.Errorf("got ChatID=%d, want 7", employees[0].ChatID)
+	}
+}
+
+// withTestDB points the package-level db at a fresh in-memory SQLite
+// database with just the employees table, and restores the previous db on
+// cleanup so tests don't leak state into each other.
+func withTestDB(t *testing.T) {
+	t.Helper()
+
+	testDB, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("не удалось открыть тестовую БД: %v", err)
+	}
+	if _, err := testDB.Exec(`CREATE TABLE employees (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		birthday DATE NOT NULL,
+		chat_id INTEGER NOT NULL
+	)`); err != nil {
+		t.Fatalf("не удалось создать таблицу employees: %v", err)
+	}
+
+	previous := db
+	db = testDB
+	t.Cleanup(func() {
+		testDB.Close()
+		db = previous
+	})
+}
+
+func TestImportEmployees(t *testing.T) {
+	withTestDB(t)
+
+	employees := []Employee{
+		{Name: "Иван Иванов", Birthday: time.Date(1990, 5, 15, 0, 0, 0, 0, time.UTC), ChatID: 1},
+		{Name: "Пётр Петров", Birthday: time.Date(1991, 12, 31, 0, 0, 0, 0, time.UTC), ChatID: 1},
+	}
+
+	added, skipped, err := importEmployees(employees)
+	if err != nil {
+		t.Fatalf("importEmployees вернул ошибку: %v", err)
+	}
+	if added != 2 || skipped != 0 {
+		t.Fatalf("got added=%d skipped=%d, want added=2 skipped=0", added, skipped)
+	}
+
+	stored, err := getEmployeesByChat(1)
+	if err != nil {
+		t.Fatalf("getEmployeesByChat вернул ошибку: %v", err)
+	}
+	if len(stored) != 2 {
+		t.Fatalf("в БД оказалось %d сотрудников, ожидалось 2", len(stored))
+	}
+}
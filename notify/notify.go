@@ -0,0 +1,277 @@
+// Package notify implements the birthday-notification delivery pipeline:
+// deciding which notifications are due (CheckNext) and sending them with
+// retry, rate limiting and dedup (Mailing).
+package notify
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/telebot.v3"
+)
+
+// Type classifies a Notification so callers can vary wording or behaviour.
+type Type string
+
+const (
+	TypeUpcoming    Type = "upcoming"
+	TypeToday       Type = "today"
+	TypeChangedDate Type = "changed_date"
+)
+
+// Employee is the subset of employee data the pipeline needs.
+type Employee struct {
+	ID       int
+	Name     string
+	Birthday time.Time
+	ChatID   int64
+}
+
+// ChatSettings is the subset of a chat's notification preferences the
+// pipeline needs to decide whether a notification is due.
+type ChatSettings struct {
+	ChatID     int64
+	Thresholds []int
+}
+
+// Notification describes one employee/chat birthday reminder due to be sent.
+type Notification struct {
+	Type       Type
+	EmployeeID int
+	ChatID     int64
+	Name       string
+	DaysUntil  int
+	Birthday   time.Time
+	Year       int
+}
+
+// MessageFunc renders the text for a Notification. Wording stays with the
+// caller (copy, emoji, locale); this package only handles delivery mechanics.
+type MessageFunc func(Notification) string
+
+var db *sql.DB
+
+// Init wires the package to the bot's database and ensures the
+// sent_notifications dedup table exists.
+func Init(database *sql.DB) error {
+	db = database
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS sent_notifications (
+		employee_id INTEGER NOT NULL,
+		chat_id INTEGER NOT NULL,
+		notify_day INTEGER NOT NULL,
+		sent_year INTEGER NOT NULL,
+		sent_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (employee_id, chat_id, notify_day, sent_year)
+	)`)
+	return err
+}
+
+// CheckNext returns the notifications due at now for the given employees,
+// scoped to each employee's own chat and that chat's configured thresholds,
+// skipping anything already recorded in sent_notifications so restarts and
+// multiple replicas don't double-send. An employee is only ever matched
+// against settingsByChat[employee.ChatID] — never every chat in the map —
+// so employees from one chat can't trigger notifications in another.
+func CheckNext(now time.Time, employees []Employee, settingsByChat map[int64]ChatSettings) ([]Notification, error) {
+	var due []Notification
+
+	for _, emp := range employees {
+		settings, ok := settingsByChat[emp.ChatID]
+		if !ok {
+			continue
+		}
+
+		daysUntil := DaysUntilBirthday(emp.Birthday, now)
+		if !contains(settings.Thresholds, daysUntil) {
+			continue
+		}
+
+		sent, err := alreadySent(emp.ID, emp.ChatID, daysUntil, now.Year())
+		if err != nil {
+			return nil, err
+		}
+		if sent {
+			continue
+		}
+
+		typ := TypeUpcoming
+		if daysUntil == 0 {
+			typ = TypeToday
+		}
+
+		due = append(due, Notification{
+			Type:       typ,
+			EmployeeID: emp.ID,
+			ChatID:     emp.ChatID,
+			Name:       emp.Name,
+			DaysUntil:  daysUntil,
+			Birthday:   emp.Birthday,
+			Year:       now.Year(),
+		})
+	}
+
+	return due, nil
+}
+
+// Mailing sends due notifications with exponential-backoff retry on
+// Telegram 429/5xx responses, and rate limiting that respects Telegram's
+// global 30 msg/sec and per-chat 1 msg/sec limits. Each successful send is
+// recorded in sent_notifications so it isn't repeated. Mailing keeps going
+// on per-notification send errors and returns the first one encountered.
+func Mailing(bot *telebot.Bot, notifications []Notification, message MessageFunc) error {
+	limiter := newRateLimiter(time.Second/30, time.Second)
+
+	var firstErr error
+	for _, n := range notifications {
+		limiter.wait(n.ChatID)
+
+		if err := sendWithRetry(bot, n, message); err != nil {
+			log.Printf("notify: не удалось отправить уведомление сотруднику %d в чат %d: %v", n.EmployeeID, n.ChatID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		if err := markSent(n.EmployeeID, n.ChatID, n.DaysUntil, n.Year); err != nil {
+			log.Printf("notify: не удалось сохранить отметку об отправке (employee %d, chat %d): %v", n.EmployeeID, n.ChatID, err)
+		}
+	}
+
+	return firstErr
+}
+
+const maxSendAttempts = 5
+
+func sendWithRetry(bot *telebot.Bot, n Notification, message MessageFunc) error {
+	backoff := 500 * time.Millisecond
+
+	var lastErr error
+	for attempt := 0; attempt < maxSendAttempts; attempt++ {
+		if _, err := bot.Send(telebot.ChatID(n.ChatID), message(n)); err != nil {
+			lastErr = err
+			if !isRetryable(err) {
+				return err
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+			continue
+		}
+		return nil
+	}
+
+	return lastErr
+}
+
+// isRetryable reports whether err looks like a transient Telegram error
+// (429 Too Many Requests or a 5xx) worth retrying. Matched on the error
+// text rather than a concrete telebot error type, since telebot surfaces
+// API errors in several shapes depending on the endpoint.
+func isRetryable(err error) bool {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "429"), strings.Contains(msg, "Too Many Requests"):
+		return true
+	case strings.Contains(msg, "Internal Server Error"),
+		strings.Contains(msg, "Bad Gateway"),
+		strings.Contains(msg, "Service Unavailable"),
+		strings.Contains(msg, "Gateway Timeout"):
+		return true
+	default:
+		return false
+	}
+}
+
+func alreadySent(employeeID int, chatID int64, day int, year int) (bool, error) {
+	var count int
+	err := db.QueryRow(
+		"SELECT COUNT(*) FROM sent_notifications WHERE employee_id = ? AND chat_id = ? AND notify_day = ? AND sent_year = ?",
+		employeeID, chatID, day, year,
+	).Scan(&count)
+	return count > 0, err
+}
+
+func markSent(employeeID int, chatID int64, day int, year int) error {
+	_, err := db.Exec(
+		"INSERT OR IGNORE INTO sent_notifications (employee_id, chat_id, notify_day, sent_year, sent_at) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)",
+		employeeID, chatID, day, year,
+	)
+	return err
+}
+
+func contains(xs []int, x int) bool {
+	for _, v := range xs {
+		if v == x {
+			return true
+		}
+	}
+	return false
+}
+
+// DaysUntilBirthday mirrors the date-only, UTC-normalized calculation the
+// bot uses throughout, parameterized on now so callers (and CheckNext)
+// don't depend on the wall clock.
+func DaysUntilBirthday(birthday time.Time, now time.Time) int {
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	birthdayUTC := time.Date(birthday.Year(), birthday.Month(), birthday.Day(), 0, 0, 0, 0, time.UTC)
+	birthdayThisYear := time.Date(now.Year(), birthdayUTC.Month(), birthdayUTC.Day(), 0, 0, 0, 0, time.UTC)
+
+	if today.After(birthdayThisYear) {
+		birthdayThisYear = birthdayThisYear.AddDate(1, 0, 0)
+	}
+
+	days := int(birthdayThisYear.Sub(today).Hours() / 24)
+	if days < 0 {
+		days = 0
+	}
+
+	return days
+}
+
+// rateLimiter enforces a minimum gap between sends globally and per chat,
+// matching Telegram's 30 msg/sec global and 1 msg/sec per-chat limits.
+type rateLimiter struct {
+	mu          sync.Mutex
+	globalGap   time.Duration
+	chatGap     time.Duration
+	globalNext  time.Time
+	perChatNext map[int64]time.Time
+}
+
+func newRateLimiter(globalGap, chatGap time.Duration) *rateLimiter {
+	return &rateLimiter{
+		globalGap:   globalGap,
+		chatGap:     chatGap,
+		perChatNext: make(map[int64]time.Time),
+	}
+}
+
+func (r *rateLimiter) wait(chatID int64) {
+	r.mu.Lock()
+	now := time.Now()
+
+	var wait time.Duration
+	if now.Before(r.globalNext) {
+		wait = r.globalNext.Sub(now)
+	}
+	if chatNext, ok := r.perChatNext[chatID]; ok {
+		if ready := now.Add(wait); ready.Before(chatNext) {
+			wait = chatNext.Sub(now)
+		}
+	}
+
+	sendAt := now.Add(wait)
+	r.globalNext = sendAt.Add(r.globalGap)
+	r.perChatNext[chatID] = sendAt.Add(r.chatGap)
+	r.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}